@@ -0,0 +1,332 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/u-root/iscsinl"
+	"github.com/u-root/u-root/pkg/cmdline"
+	"github.com/u-root/u-root/pkg/dhclient"
+	slaunch "github.com/u-root/u-root/pkg/securelaunch"
+)
+
+// dracutNetrootPrefix is the scheme a dracut iscsi netroot= value starts
+// with; anything else on the netroot= line is a different root type we
+// don't handle here.
+const dracutNetrootPrefix = "iscsi:"
+
+// ibftFirmwarePath is where the kernel exposes an iSCSI Boot Firmware Table
+// parsed out of ACPI, when rd.iscsi.firmware is requested instead of an
+// explicit netroot=.
+const ibftFirmwarePath = "/sys/firmware/ibft"
+
+// iscsiTarget describes one dracut-style iSCSI target: the optional CHAP
+// credentials (both the target's and, for mutual CHAP, the initiator's),
+// the portal to dial, and the IQN to log in to.
+type iscsiTarget struct {
+	Username      string
+	Password      string
+	InitiatorUser string
+	InitiatorPass string
+	Host          string
+	Protocol      string
+	Port          string
+	LUN           string
+	TargetName    string
+}
+
+// parseNetroot parses one dracut netroot=iscsi:... value into an
+// iscsiTarget. The full schema, from the dracut iscsi.sh dkfs, is:
+//
+//	iscsi:<username>:<password>:<initiator-user>:<initiator-pass>@<host>:<protocol>:<port>:<lun>:<targetname>
+//
+// every field left of '@' may be empty when CHAP is not in use, e.g.
+// "iscsi::::@10.0.0.1:6:3260:0:iqn.2020-01.com.example:target0".
+func parseNetroot(val string) (*iscsiTarget, error) {
+	if !strings.HasPrefix(val, dracutNetrootPrefix) {
+		return nil, fmt.Errorf("%q: not an iscsi netroot, want prefix %q", val, dracutNetrootPrefix)
+	}
+	val = strings.TrimPrefix(val, dracutNetrootPrefix)
+
+	i := strings.IndexByte(val, '@')
+	if i < 0 {
+		return nil, fmt.Errorf("%q: missing '@' separating auth from target", val)
+	}
+	auth, rest := val[:i], val[i+1:]
+
+	authFields := strings.Split(auth, ":")
+	if len(authFields) != 4 {
+		return nil, fmt.Errorf("%q: expected 4 ':'-separated auth fields before '@' (username:password:initiator-user:initiator-pass), got %d", auth, len(authFields))
+	}
+
+	// rest = <host>:<protocol>:<port>:<lun>:<targetname>; targetname itself
+	// may legitimately contain ':', so cap the split at 5 fields.
+	restFields := strings.SplitN(rest, ":", 5)
+	if len(restFields) != 5 {
+		return nil, fmt.Errorf("%q: expected host:protocol:port:lun:targetname, got %d fields", rest, len(restFields))
+	}
+
+	return &iscsiTarget{
+		Username:      authFields[0],
+		Password:      authFields[1],
+		InitiatorUser: authFields[2],
+		InitiatorPass: authFields[3],
+		Host:          restFields[0],
+		Protocol:      restFields[1],
+		Port:          restFields[2],
+		LUN:           restFields[3],
+		TargetName:    restFields[4],
+	}, nil
+}
+
+// allNetroots returns every netroot= value on the kernel cmdline, in the
+// order they appear. dracut allows repeating netroot= once per target when
+// rd.iscsi.mpath is requested, and cmdline.Flag only ever returns the first
+// match, so this re-scans the raw cmdline directly.
+func allNetroots() []string {
+	raw, err := ioutil.ReadFile("/proc/cmdline")
+	if err != nil {
+		slaunch.Debug("allNetroots: could not read /proc/cmdline: err=[%v]", err)
+		return nil
+	}
+	return parseNetrootValues(string(raw))
+}
+
+// parseNetrootValues extracts every netroot= value out of a raw kernel
+// cmdline string, split out of allNetroots so the parsing can be unit
+// tested without a /proc/cmdline to read.
+func parseNetrootValues(rawCmdline string) []string {
+	var vals []string
+	for _, field := range strings.Fields(rawCmdline) {
+		if strings.HasPrefix(field, "netroot=") {
+			vals = append(vals, strings.TrimPrefix(field, "netroot="))
+		}
+	}
+	return vals
+}
+
+// targetsFromFirmware builds one iscsiTarget per target block the kernel's
+// ibft driver exposed under ibftFirmwarePath.
+func targetsFromFirmware() ([]*iscsiTarget, error) {
+	entries, err := ioutil.ReadDir(ibftFirmwarePath)
+	if err != nil {
+		return nil, fmt.Errorf("rd.iscsi.firmware set but %s unreadable: %w", ibftFirmwarePath, err)
+	}
+
+	var targets []*iscsiTarget
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), "target") {
+			continue
+		}
+		base := ibftFirmwarePath + "/" + e.Name()
+		targets = append(targets, &iscsiTarget{
+			Host:       readIBFTAttr(base, "ip-addr"),
+			Port:       readIBFTAttr(base, "port"),
+			LUN:        readIBFTAttr(base, "lun"),
+			TargetName: readIBFTAttr(base, "target-name"),
+			Username:   readIBFTAttr(base, "chap-name"),
+			Password:   readIBFTAttr(base, "chap-secret"),
+		})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets found under %s", ibftFirmwarePath)
+	}
+	return targets, nil
+}
+
+// readIBFTAttr best-effort reads one attribute file under an ibft sysfs
+// target directory; an unreadable or absent attribute is left empty rather
+// than treated as fatal, since not every field is populated by every
+// firmware.
+func readIBFTAttr(dir, attr string) string {
+	b, err := ioutil.ReadFile(dir + "/" + attr)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// applyCmdlineOverrides fills in credentials from the standalone
+// rd.iscsi.* flags, which dracut honors in addition to (and overriding) the
+// inline netroot=iscsi: syntax.
+func applyCmdlineOverrides(t *iscsiTarget) {
+	if v, ok := cmdline.Flag("rd.iscsi.username"); ok {
+		t.Username = v
+	}
+	if v, ok := cmdline.Flag("rd.iscsi.password"); ok {
+		t.Password = v
+	}
+}
+
+// wantsMultipath reports whether rd.iscsi.mpath was set on the cmdline,
+// requesting that every netroot= target be logged in to rather than
+// aborting on the first failure.
+func wantsMultipath() bool {
+	_, ok := cmdline.Flag("rd.iscsi.mpath")
+	return ok
+}
+
+// login dials and, if credentials are present, authenticates against t,
+// returning the resulting block devices.
+func (t *iscsiTarget) login(initiatorName string) ([]string, error) {
+	opts := []iscsinl.Option{
+		iscsinl.WithInitiator(initiatorName),
+		iscsinl.WithTarget(t.Host+":"+t.Port, t.TargetName),
+		iscsinl.WithCmdsMax(128),
+		iscsinl.WithQueueDepth(16),
+		iscsinl.WithScheduler("noop"),
+		iscsinl.WithHeaderDigest(),
+	}
+
+	if t.Username != "" {
+		opts = append(opts, iscsinl.WithCredentials(t.Username, t.Password))
+	}
+	if t.InitiatorUser != "" {
+		opts = append(opts, iscsinl.WithMutualCredentials(t.InitiatorUser, t.InitiatorPass))
+	}
+
+	return iscsinl.MountIscsi(opts...)
+}
+
+// loginAll logs in to every target in order. Without rd.iscsi.mpath, the
+// first failure aborts immediately, matching the historical single-target
+// behavior; with rd.iscsi.mpath every target is attempted, since mpath
+// exists precisely to tolerate one path being unavailable.
+func loginAll(targets []*iscsiTarget) error {
+	log.Println("Scanning kernel cmd line for *rd.iscsi.initiator* flag")
+	initiatorName, ok := cmdline.Flag("rd.iscsi.initiator")
+	if !ok {
+		return errors.New("rd.iscsi.initiator flag is not set")
+	}
+
+	mpath := wantsMultipath()
+	loggedIn := 0
+	for _, t := range targets {
+		devices, err := t.login(initiatorName)
+		if err != nil {
+			log.Printf("iscsi login to %s:%s %s failed: %v", t.Host, t.Port, t.TargetName, err)
+			if !mpath {
+				return err
+			}
+			continue
+		}
+		loggedIn++
+		for _, d := range devices {
+			log.Println("Mounted at dev ", d)
+		}
+	}
+	if loggedIn == 0 {
+		return errors.New("no iscsi targets could be logged in to")
+	}
+	return nil
+}
+
+// dhcpKeywords are the ip= autoconf values this treats as "use DHCP".
+// dracut/klibc also accept dhcp6/auto6 for IPv6 autoconfiguration, which
+// isn't handled here.
+var dhcpKeywords = map[string]bool{
+	"dhcp": true,
+	"on":   true,
+	"any":  true,
+}
+
+// wantsDHCP reports whether fields - ip='s value already split on ':' -
+// requests DHCP autoconfiguration, and if so, which interface to run it on.
+// dracut accepts the DHCP keyword in three places: the bare "ip=dhcp" form
+// (1 field), the short "ip=<device>:dhcp" form (2 fields), and the full
+// client-ip:server-ip:gateway:netmask:hostname:iface:autoconf form's
+// trailing 7th field.
+func wantsDHCP(fields []string) (iface string, ok bool) {
+	switch len(fields) {
+	case 1:
+		return "eth0", dhcpKeywords[fields[0]]
+	case 2:
+		return fields[0], dhcpKeywords[fields[1]]
+	default:
+		if len(fields) < 7 || !dhcpKeywords[fields[6]] {
+			return "", false
+		}
+		if fields[5] != "" {
+			return fields[5], true
+		}
+		return "eth0", true
+	}
+}
+
+// bringUpNetwork parses ip= (the dracut/klibc syntax) and configures the
+// named interface via DHCP or a static address (client-ip/gateway/netmask)
+// before any iscsi login is attempted, since the portal is unreachable
+// until the NIC is up. It is not an error for ip= to be unset: the network
+// may already be configured, e.g. by firmware.
+func bringUpNetwork() error {
+	val, ok := cmdline.Flag("ip")
+	if !ok {
+		slaunch.Debug("bringUpNetwork: ip= not set, assuming network is already configured")
+		return nil
+	}
+
+	// ip=<client-ip>:<server-ip>:<gateway>:<netmask>:<hostname>:<iface>:<autoconf>
+	fields := strings.Split(val, ":")
+
+	if iface, ok := wantsDHCP(fields); ok {
+		return dhclient.SendRequest([]string{iface}, true, false, false, 0)
+	}
+
+	// static: ip=<client-ip>:<server-ip>:<gateway>:<netmask>:...
+	if len(fields) < 4 || fields[0] == "" || fields[3] == "" {
+		return fmt.Errorf("ip=%s: expected at least client-ip:server-ip:gateway:netmask for a static config, or a dhcp/on/any autoconf keyword", val)
+	}
+	iface := "eth0"
+	if len(fields) >= 6 && fields[5] != "" {
+		iface = fields[5]
+	}
+	return configureStaticAddress(iface, fields[0], fields[2], fields[3])
+}
+
+// configureStaticAddress brings iface up with a static address/netmask and,
+// if given, a default route, by shelling out to the ip command - the same
+// approach u-root's own early-network initramfs scripts use, since no
+// in-process netlink client is vendored here.
+func configureStaticAddress(iface, ipAddr, gateway, netmask string) error {
+	prefix, err := netmaskToPrefixLen(netmask)
+	if err != nil {
+		return err
+	}
+
+	if out, err := exec.Command("ip", "addr", "add", fmt.Sprintf("%s/%d", ipAddr, prefix), "dev", iface).CombinedOutput(); err != nil {
+		return fmt.Errorf("ip addr add: %w: %s", err, out)
+	}
+	if out, err := exec.Command("ip", "link", "set", iface, "up").CombinedOutput(); err != nil {
+		return fmt.Errorf("ip link set up: %w: %s", err, out)
+	}
+	if gateway == "" {
+		return nil
+	}
+	if out, err := exec.Command("ip", "route", "add", "default", "via", gateway).CombinedOutput(); err != nil {
+		return fmt.Errorf("ip route add default: %w: %s", err, out)
+	}
+	return nil
+}
+
+// netmaskToPrefixLen converts a dotted-decimal netmask (e.g. "255.255.255.0")
+// into a CIDR prefix length.
+func netmaskToPrefixLen(netmask string) (int, error) {
+	ip := net.ParseIP(netmask).To4()
+	if ip == nil {
+		return 0, fmt.Errorf("invalid netmask %q", netmask)
+	}
+	ones, bits := net.IPMask(ip).Size()
+	if ones == 0 && bits == 0 {
+		return 0, fmt.Errorf("invalid netmask %q", netmask)
+	}
+	return ones, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNetroot(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		val     string
+		want    *iscsiTarget
+		wantErr bool
+	}{
+		{
+			name: "no chap",
+			val:  "iscsi::::@10.0.0.1:6:3260:0:iqn.2020-01.com.example:target0",
+			want: &iscsiTarget{
+				Host:       "10.0.0.1",
+				Protocol:   "6",
+				Port:       "3260",
+				LUN:        "0",
+				TargetName: "iqn.2020-01.com.example:target0",
+			},
+		},
+		{
+			name: "chap",
+			val:  "iscsi:user:pass::@10.0.0.1:6:3260:0:iqn.2020-01.com.example:target0",
+			want: &iscsiTarget{
+				Username:   "user",
+				Password:   "pass",
+				Host:       "10.0.0.1",
+				Protocol:   "6",
+				Port:       "3260",
+				LUN:        "0",
+				TargetName: "iqn.2020-01.com.example:target0",
+			},
+		},
+		{
+			name: "mutual chap",
+			val:  "iscsi:user:pass:iuser:ipass@10.0.0.1:6:3260:0:iqn.2020-01.com.example:target0",
+			want: &iscsiTarget{
+				Username:      "user",
+				Password:      "pass",
+				InitiatorUser: "iuser",
+				InitiatorPass: "ipass",
+				Host:          "10.0.0.1",
+				Protocol:      "6",
+				Port:          "3260",
+				LUN:           "0",
+				TargetName:    "iqn.2020-01.com.example:target0",
+			},
+		},
+		{
+			name:    "missing scheme",
+			val:     "10.0.0.1::3260::iqn.foo",
+			wantErr: true,
+		},
+		{
+			name:    "missing @",
+			val:     "iscsi:10.0.0.1:6:3260:0:iqn.foo",
+			wantErr: true,
+		},
+		{
+			name:    "too few auth fields",
+			val:     "iscsi:user:pass@10.0.0.1:6:3260:0:iqn.foo",
+			wantErr: true,
+		},
+		{
+			name:    "too few target fields",
+			val:     "iscsi::::@10.0.0.1:6:3260",
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNetroot(tt.val)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNetroot(%q) err = %v, wantErr = %v", tt.val, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseNetroot(%q) = %+v, want %+v", tt.val, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNetrootValues(t *testing.T) {
+	cmdline := "BOOT_IMAGE=/vmlinuz netroot=iscsi:::::@10.0.0.1:6:3260:0:iqn.foo:a rd.iscsi.mpath netroot=iscsi:::::@10.0.0.2:6:3260:0:iqn.foo:a console=ttyS0"
+	want := []string{
+		"iscsi:::::@10.0.0.1:6:3260:0:iqn.foo:a",
+		"iscsi:::::@10.0.0.2:6:3260:0:iqn.foo:a",
+	}
+
+	got := parseNetrootValues(cmdline)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseNetrootValues(%q) = %v, want %v", cmdline, got, want)
+	}
+}
+
+func TestParseNetrootValuesNone(t *testing.T) {
+	if got := parseNetrootValues("BOOT_IMAGE=/vmlinuz console=ttyS0"); got != nil {
+		t.Errorf("parseNetrootValues() = %v, want nil", got)
+	}
+}
+
+func TestWantsDHCP(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		val       string
+		wantIface string
+		wantOK    bool
+	}{
+		{name: "bare dhcp", val: "dhcp", wantIface: "eth0", wantOK: true},
+		{name: "bare on", val: "on", wantIface: "eth0", wantOK: true},
+		{name: "bare any", val: "any", wantIface: "eth0", wantOK: true},
+		{name: "device short form", val: "eth1:dhcp", wantIface: "eth1", wantOK: true},
+		{name: "full form with iface", val: "::::host:eth1:dhcp", wantIface: "eth1", wantOK: true},
+		{name: "full form without iface", val: "::::host::dhcp", wantIface: "eth0", wantOK: true},
+		{name: "static config", val: "10.0.0.2:10.0.0.1:10.0.0.1:255.255.255.0:host:eth0:static", wantOK: false},
+		{name: "bare static value", val: "10.0.0.2", wantOK: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			iface, ok := wantsDHCP(strings.Split(tt.val, ":"))
+			if ok != tt.wantOK {
+				t.Fatalf("wantsDHCP(%q) ok = %v, want %v", tt.val, ok, tt.wantOK)
+			}
+			if ok && iface != tt.wantIface {
+				t.Errorf("wantsDHCP(%q) iface = %q, want %q", tt.val, iface, tt.wantIface)
+			}
+		})
+	}
+}
+
+func TestNetmaskToPrefixLen(t *testing.T) {
+	for _, tt := range []struct {
+		netmask string
+		want    int
+		wantErr bool
+	}{
+		{netmask: "255.255.255.0", want: 24},
+		{netmask: "255.255.0.0", want: 16},
+		{netmask: "255.255.255.255", want: 32},
+		{netmask: "not-an-ip", wantErr: true},
+	} {
+		got, err := netmaskToPrefixLen(tt.netmask)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("netmaskToPrefixLen(%q) err = %v, wantErr = %v", tt.netmask, err, tt.wantErr)
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("netmaskToPrefixLen(%q) = %d, want %d", tt.netmask, got, tt.want)
+		}
+	}
+}
@@ -8,13 +8,11 @@ import (
 	// "bytes"
 	"errors"
 	"flag"
-	"fmt"
 	"log"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/u-root/iscsinl"
 	"github.com/u-root/u-root/pkg/cmdline"
 	slaunch "github.com/u-root/u-root/pkg/securelaunch"
 	"github.com/u-root/u-root/pkg/securelaunch/policy"
@@ -140,60 +138,45 @@ func unmountAndExit() {
 	os.Exit(1)
 }
 
-// scanIscsiDrives calls iscsinl to mount iscsi drives.
-// format: netroot=iscsi:@X.Y.Z.W::3260::iqn.FOO.com.abc:hostname-boot
+// scanIscsiDrives brings up the network if needed and logs in to every
+// iscsi target described on the kernel cmdline or, failing that, in the
+// iBFT firmware table. See iscsi.go for the dracut netroot=/rd.iscsi.*
+// parsing this builds on.
 func scanIscsiDrives() error {
-
-	log.Println("Scanning kernel cmd line for *netroot* flag")
-	val, ok := cmdline.Flag("netroot")
-	if !ok {
-		return errors.New("netroot flag is not set")
-	}
-
-	// val = iscsi:@10.196.210.62::3260::iqn.1986-03.com.sun:ovs112-boot
-	log.Println("netroot flag is set with val=", val)
-	s := strings.Split(val, "::")
-	if len(s) != 3 {
-		return fmt.Errorf("%v: incorrect format ::,  Usage: netroot=iscsi:@10.X.Y.Z::1224::iqn.foo:hostname-bar, [Expecting len(%s) = 3] ", val, s)
+	if err := bringUpNetwork(); err != nil {
+		log.Printf("failed to bring up network for iscsi login: %v", err)
 	}
 
-	// s[0] = iscsi:@10.196.210.62 or iscsi:@10.196.210.62,2
-	// s[1] = 3260
-	// s[2] = iqn.1986-03.com.sun:ovs112-boot
-	port := s[1]
-	volume := s[2]
-
-	// split s[0] into tmp[1] and tmp[2]
-	tmp := strings.Split(s[0], ":@")
-	if len(tmp) > 3 || len(tmp) < 2 {
-		return fmt.Errorf("%v: incorrect format :@, Usage: netroot=iscsi:@10.X.Y.Z::1224::iqn.foo:hostname-bar, [ Expecting 2 <= len(%s) <= 3", val, tmp)
-	}
-
-	if tmp[0] != "iscsi" {
-		return fmt.Errorf("%v: incorrect format iscsi:, Usage: netroot=iscsi:@10.X.Y.Z::1224::iqn.foo:hostname-bar, [ %s != 'iscsi'] ", val, tmp[0])
-	}
+	log.Println("Scanning kernel cmd line for *netroot* flag(s)")
+	netroots := allNetroots()
 
-	ip := tmp[1] + ":" + port
+	var targets []*iscsiTarget
+	if len(netroots) == 0 {
+		if _, ok := cmdline.Flag("rd.iscsi.firmware"); !ok {
+			return errors.New("no netroot=iscsi:... targets and rd.iscsi.firmware not set")
+		}
 
-	log.Println("Scanning kernel cmd line for *rd.iscsi.initiator* flag")
-	initiatorName, ok := cmdline.Flag("rd.iscsi.initiator")
-	if !ok {
-		return errors.New("rd.iscsi.initiator flag is not set")
+		fwTargets, err := targetsFromFirmware()
+		if err != nil {
+			return err
+		}
+		targets = fwTargets
+	} else {
+		for _, val := range netroots {
+			t, err := parseNetroot(val)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, t)
+		}
 	}
 
-	devices, err := iscsinl.MountIscsi(
-		iscsinl.WithInitiator(initiatorName),
-		iscsinl.WithTarget(ip, volume),
-		iscsinl.WithCmdsMax(128),
-		iscsinl.WithQueueDepth(16),
-		iscsinl.WithScheduler("noop"),
-	)
-	if err != nil {
-		return err
+	// rd.iscsi.username/rd.iscsi.password override CHAP credentials for
+	// every target, regardless of whether it came from netroot= or was
+	// discovered via rd.iscsi.firmware.
+	for _, t := range targets {
+		applyCmdlineOverrides(t)
 	}
 
-	for i := range devices {
-		log.Println("Mounted at dev ", devices[i])
-	}
-	return nil
+	return loginAll(targets)
 }
@@ -0,0 +1,67 @@
+// Copyright 2017-2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzip
+
+import (
+	stdgzip "compress/gzip"
+	"io"
+
+	"github.com/klauspost/pgzip"
+)
+
+// Compress takes input from io.Reader and compresses it using pgzip to
+// io.Writer, writing in blocksize (KB) chunks spread across upto the number
+// of CPU cores specified by processes. level is a compress/gzip level
+// (gzip.DefaultCompression, gzip.BestSpeed, gzip.BestCompression, ...).
+//
+// processes <= 1 falls back to the stdlib compress/gzip writer: pgzip's
+// concurrency machinery only pays for itself with more than one worker, and
+// the stdlib writer produces byte-identical output for the single-threaded
+// case.
+func Compress(r io.Reader, w io.Writer, blocksize int, processes int, level int) error {
+	zw, err := NewWriter(w, blocksize, processes, level)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(zw, r); err != nil {
+		zw.Close()
+		return err
+	}
+
+	return zw.Close()
+}
+
+// NewWriter returns an io.WriteCloser that gzip-compresses everything
+// written to it into w, so that large measurement logs and other payloads
+// can be streamed out without buffering the whole thing in memory first.
+func NewWriter(w io.Writer, blocksize int, processes int, level int) (io.WriteCloser, error) {
+	if processes <= 1 {
+		return stdgzip.NewWriterLevel(w, level)
+	}
+
+	zw, err := pgzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	if err := zw.SetConcurrency(blocksize*1024, processes); err != nil {
+		zw.Close()
+		return nil, err
+	}
+	return zw, nil
+}
+
+// NewReader returns an io.ReadCloser that gzip-decompresses r, reading in
+// blocksize (KB) chunks spread across upto the number of CPU cores
+// specified by processes.
+//
+// processes <= 1 falls back to the stdlib compress/gzip reader.
+func NewReader(r io.Reader, blocksize int, processes int) (io.ReadCloser, error) {
+	if processes <= 1 {
+		return stdgzip.NewReader(r)
+	}
+
+	return pgzip.NewReaderN(r, blocksize*1024, processes)
+}
@@ -0,0 +1,83 @@
+// Copyright 2017-2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// payload returns n bytes of data compressible enough to exercise pgzip's
+// worker blocks without compressing away to nothing.
+func payload(n int) []byte {
+	b := make([]byte, n)
+	r := rand.New(rand.NewSource(1))
+	for i := range b {
+		b[i] = byte(r.Intn(8))
+	}
+	return b
+}
+
+func TestCompressDecompressRoundTrip(t *testing.T) {
+	in := payload(1 << 20) // 1 MiB
+
+	var compressed bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &compressed, 512, 4, gzip.DefaultCompression); err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Decompress(&compressed, &out, 512, 4); err != nil {
+		t.Fatalf("Decompress: %v", err)
+	}
+
+	if !bytes.Equal(in, out.Bytes()) {
+		t.Errorf("round trip mismatch: got %d bytes, want %d bytes", out.Len(), len(in))
+	}
+}
+
+func TestCompressFallbackSingleProcess(t *testing.T) {
+	in := payload(1 << 16)
+
+	var compressed bytes.Buffer
+	if err := Compress(bytes.NewReader(in), &compressed, 512, 1, gzip.DefaultCompression); err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+
+	zr, err := gzip.NewReader(&compressed)
+	if err != nil {
+		t.Fatalf("stdlib gzip could not read Compress(processes=1) output: %v", err)
+	}
+	defer zr.Close()
+
+	out, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Errorf("fallback round trip mismatch: got %d bytes, want %d bytes", len(out), len(in))
+	}
+}
+
+func benchmarkCompress(b *testing.B, processes int) {
+	in := payload(8 << 20) // 8 MiB
+	b.SetBytes(int64(len(in)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := Compress(bytes.NewReader(in), io.Discard, 512, processes, gzip.DefaultCompression); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompressSerial(b *testing.B)    { benchmarkCompress(b, 1) }
+func BenchmarkCompressParallel2(b *testing.B) { benchmarkCompress(b, 2) }
+func BenchmarkCompressParallel4(b *testing.B) { benchmarkCompress(b, 4) }
+func BenchmarkCompressParallel8(b *testing.B) { benchmarkCompress(b, 8) }
@@ -0,0 +1,77 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package collector defines the Collector interface that measurement
+// collectors implement, and the "type"-keyed registry the policy package
+// uses to deserialize a heterogeneous collector list out of a policy file.
+//
+// It exists as its own leaf package so that policy can parse a policy file
+// without importing every collector package, while a collector package
+// (like measurement) can register itself here without policy needing to
+// import it back.
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Collector measures some piece of platform or kernel state into the TPM.
+type Collector interface {
+	Collect() error
+}
+
+// builders maps a policy file's collector "type" string to a constructor
+// for it. Each collector implementation registers itself here from an
+// init().
+var builders = map[string]func() Collector{}
+
+// Register makes a collector type available under typeName in a policy
+// file's "collectors" list. It is meant to be called from an init() in the
+// collector's own package, e.g.:
+//
+//	func init() {
+//		collector.Register("cpufeature", func() collector.Collector { return &CPUFeatureCollector{} })
+//	}
+func Register(typeName string, build func() Collector) {
+	builders[typeName] = build
+}
+
+// List is a policy file's list of collectors, deserialized by dispatching
+// each entry's "type" field to whichever collector registered against it
+// via Register.
+type List []Collector
+
+// UnmarshalJSON implements json.Unmarshaler, dispatching each array element
+// to the Collector type named by its "type" field.
+func (l *List) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	list := make(List, 0, len(raw))
+	for _, r := range raw {
+		var header struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(r, &header); err != nil {
+			return err
+		}
+
+		build, ok := builders[header.Type]
+		if !ok {
+			return fmt.Errorf("collector: unknown collector type %q", header.Type)
+		}
+
+		c := build()
+		if err := json.Unmarshal(r, c); err != nil {
+			return err
+		}
+		list = append(list, c)
+	}
+
+	*l = list
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measurement
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/cpuid"
+	slaunch "github.com/u-root/u-root/pkg/securelaunch"
+	"github.com/u-root/u-root/pkg/securelaunch/collector"
+)
+
+// cpuFeatureEvent is the event description this collector logs under,
+// distinct from the file/disk/dmi/cpuid collectors' events.
+const cpuFeatureEvent = "secure launch: measured cpu feature evidence into pcr"
+
+func init() {
+	collector.Register("cpufeature", func() collector.Collector { return &CPUFeatureCollector{} })
+}
+
+// CPUFeatureCollector gathers the platform's runtime CPU security posture -
+// DRTM and attestation-relevant CPUID leaves, the loaded microcode
+// revision, and the SMRR/MTRR configuration - canonicalizes it into a
+// stable JSON blob, and measures its hash, alongside the existing file,
+// disk, DMI and cpuid collectors. It only records the evidence: it checks no
+// feature against the policy, and Collect succeeds whichever features
+// (e.g. IBRS, SMX) are present or absent.
+type CPUFeatureCollector struct {
+	Type string `json:"type"`
+	Pcr  uint32 `json:"pcr"`
+}
+
+// cpuFeatures mirrors the structured feature-bit approach Go's
+// internal/cpu takes: named booleans rather than raw leaf/bit numbers, so
+// the canonical JSON (and any policy that inspects it) stays stable across
+// CPUID detail changes.
+type cpuFeatures struct {
+	SMX     bool `json:"smx"`      // GETSEC/SMX, Intel TXT's entry point
+	SGX     bool `json:"sgx"`
+	TME     bool `json:"tme"`      // Total Memory Encryption
+	IBRS    bool `json:"ibrs"`     // Indirect Branch Restricted Speculation
+	IBPB    bool `json:"ibpb"`     // Indirect Branch Predictor Barrier
+	STIBP   bool `json:"stibp"`    // Single Thread Indirect Branch Predictors
+	SSBD    bool `json:"ssbd"`     // Speculative Store Bypass Disable
+	MDClear bool `json:"md_clear"` // VERW-based microarchitectural buffer clearing
+}
+
+// cpuEvidence is the canonical, hashed record this collector produces.
+type cpuEvidence struct {
+	Features     cpuFeatures       `json:"features"`
+	MicrocodeRev map[string]string `json:"microcode_rev"` // per logical cpu, e.g. "cpu0": "0x100"
+	MTRR         []string          `json:"mtrr"`          // raw /proc/mtrr lines, sorted
+}
+
+// Collect gathers CPU feature evidence and measures its hash into c.Pcr.
+func (c *CPUFeatureCollector) Collect() error {
+	slaunch.Debug("CPUFeatureCollector: pcr=[%v]", c.Pcr)
+
+	ev := cpuEvidence{
+		Features:     readCPUFeatures(),
+		MicrocodeRev: readMicrocodeRevisions(),
+		MTRR:         readMTRR(),
+	}
+
+	canonical, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("CPUFeatureCollector: failed to canonicalize evidence: %w", err)
+	}
+	slaunch.Debug("CPUFeatureCollector: evidence=[%s]", canonical)
+
+	digests, err := ComputeDigests(canonical)
+	if err != nil {
+		return fmt.Errorf("CPUFeatureCollector: failed to hash evidence: %w", err)
+	}
+
+	return SendEventToSysfs(digests, c.Pcr, cpuFeatureEvent)
+}
+
+// readCPUFeatures reads the CPUID leaves relevant to DRTM and speculative
+// execution mitigations off the current logical CPU.
+func readCPUFeatures() cpuFeatures {
+	_, _, ecx1, _ := cpuid.ID(1, 0)
+	_, ebx7, ecx7, edx7 := cpuid.ID(7, 0)
+
+	return cpuFeatures{
+		SMX:     ecx1&(1<<6) != 0,
+		SGX:     ebx7&(1<<2) != 0,
+		TME:     ecx7&(1<<13) != 0,
+		IBRS:    edx7&(1<<26) != 0,
+		IBPB:    edx7&(1<<26) != 0, // SDM enumerates IBRS and IBPB under the same bit
+		STIBP:   edx7&(1<<27) != 0,
+		SSBD:    edx7&(1<<31) != 0,
+		MDClear: edx7&(1<<10) != 0,
+	}
+}
+
+// readMicrocodeRevisions reads the loaded microcode revision for every
+// logical CPU out of /proc/cpuinfo, keyed "cpu<N>" by processor index.
+func readMicrocodeRevisions() map[string]string {
+	revisions := map[string]string{}
+
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		slaunch.Debug("readMicrocodeRevisions: could not open /proc/cpuinfo: err=[%v]", err)
+		return revisions
+	}
+	defer f.Close()
+
+	cpu := "cpu0"
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, val, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+
+		switch key {
+		case "processor":
+			cpu = "cpu" + val
+		case "microcode":
+			revisions[cpu] = val
+		}
+	}
+	return revisions
+}
+
+// readMTRR reads the firmware-programmed SMRR/MTRR ranges out of
+// /proc/mtrr, sorted for a stable canonical ordering. A platform without
+// MTRR support (or without /proc/mtrr mounted) simply contributes no
+// entries.
+func readMTRR() []string {
+	b, err := ioutil.ReadFile("/proc/mtrr")
+	if err != nil {
+		slaunch.Debug("readMTRR: could not read /proc/mtrr: err=[%v]", err)
+		return nil
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
@@ -8,23 +8,133 @@ package measurement
 import (
     "encoding/binary"
     "bytes"
+    "fmt"
+    "sort"
+    "sync"
 
 	"github.com/u-root/u-root/pkg/tss"
 	"github.com/u-root/u-root/pkg/securelaunch/eventlog"
 	slaunch "github.com/u-root/u-root/pkg/securelaunch"
 )
 
-var hashAlgo = tss.HashSHA256 // tss uses sha256
+// baseTypeTXT is the TXT specification base event value for DRTM values.
+const baseTypeTXT = 0x400
 
-// marshalPcrEvent writes structure fields piecemeal to buffer.
-func marshalPcrEvent(pcr uint32, h []byte, eventDesc []byte) ([]byte, error) {
+// slaunchType is the Secure Launch event log entry type.
+const slaunchType = uint32(baseTypeTXT + 0x102)
 
-	const baseTypeTXT = 0x400                       // TXT specification base event value for DRTM values
-	const slaunchType = uint32(baseTypeTXT + 0x102) // Secure Launch event log entry type.
-	count := uint32(1)
+// evNoAction is the TCG EV_NO_ACTION event type, used for informational
+// records - such as the Spec ID Event - that are not extended into a PCR.
+const evNoAction = uint32(0x03)
+
+// specIDEventSignature is the NUL-terminated signature of a crypto-agile
+// "Spec ID Event03" header, as defined by the TCG PC Client Platform
+// Firmware Profile.
+var specIDEventSignature = [16]byte{'S', 'p', 'e', 'c', ' ', 'I', 'D', ' ', 'E', 'v', 'e', 'n', 't', '0', '3', 0}
+
+// activeBanks lists the PCR banks this platform's TPM has enabled, as
+// reported by a TPM2_GetCapability query at startup. TCG_PCR_EVENT2 records
+// carry one digest per active bank so that crypto-agile log parsers
+// (tpm2-tools, systemd) don't have to special-case a single hash algorithm.
+// It defaults to SHA-256 alone so collectors keep working even when the
+// capability query fails or is unavailable.
+var activeBanks = []tss.HashAlg{tss.HashSHA256}
+
+// specIDEventOnce guards writing the Spec ID Event header so it is emitted
+// exactly once, before the first TCG_PCR_EVENT2 record lands in the log.
+var specIDEventOnce sync.Once
+
+func init() {
+	banks, err := tss.ActivePCRBanks()
+	if err != nil {
+		slaunch.Debug("measurement: could not query active PCR banks, defaulting to SHA256: err=[%v]", err)
+		return
+	}
+	if len(banks) > 0 {
+		activeBanks = banks
+	}
+}
+
+// sortedBanks returns activeBanks in a stable, deterministic order so that
+// identical measurements always marshal to identical bytes.
+func sortedBanks() []tss.HashAlg {
+	banks := append([]tss.HashAlg{}, activeBanks...)
+	sort.Slice(banks, func(i, j int) bool { return banks[i] < banks[j] })
+	return banks
+}
+
+// marshalSpecIDEvent builds the crypto-agile "Spec ID Event03" header record
+// that must precede the first TCG_PCR_EVENT2 entry in the log, so that
+// userspace parsers (tpm2-tools, systemd) recognize the log as crypto-agile.
+func marshalSpecIDEvent() ([]byte, error) {
+	endianess := binary.LittleEndian
+	var event bytes.Buffer
+
+	if err := binary.Write(&event, endianess, specIDEventSignature); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&event, endianess, uint32(0)); err != nil { // platformClass
+		return nil, err
+	}
+	if err := binary.Write(&event, endianess, uint8(0)); err != nil { // specVersionMinor
+		return nil, err
+	}
+	if err := binary.Write(&event, endianess, uint8(2)); err != nil { // specVersionMajor
+		return nil, err
+	}
+	if err := binary.Write(&event, endianess, uint8(0)); err != nil { // specErrata
+		return nil, err
+	}
+	if err := binary.Write(&event, endianess, uint8(2)); err != nil { // uintnSize, in 32-bit words (2: UINT64)
+		return nil, err
+	}
+
+	banks := sortedBanks()
+	if err := binary.Write(&event, endianess, uint32(len(banks))); err != nil { // numberOfAlgorithms
+		return nil, err
+	}
+	for _, alg := range banks {
+		if err := binary.Write(&event, endianess, alg); err != nil { // algorithmId
+			return nil, err
+		}
+		if err := binary.Write(&event, endianess, uint16(tss.DigestSize(alg))); err != nil { // digestSize
+			return nil, err
+		}
+	}
+	if err := binary.Write(&event, endianess, uint8(0)); err != nil { // vendorInfoSize, no vendor info
+		return nil, err
+	}
+
+	// The Spec ID Event is itself logged as a legacy TCG_PCR_EVENT (a single
+	// all-zero SHA1 digest), per spec, since it precedes any bank selection.
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, endianess, uint32(0)); err != nil { // PCRIndex
+		return nil, err
+	}
+	if err := binary.Write(&buf, endianess, evNoAction); err != nil { // EventType
+		return nil, err
+	}
+	if err := binary.Write(&buf, endianess, make([]byte, 20)); err != nil { // legacy SHA1 digest, all zero
+		return nil, err
+	}
+	if err := binary.Write(&buf, endianess, uint32(event.Len())); err != nil { // EventSize
+		return nil, err
+	}
+	if err := binary.Write(&buf, endianess, event.Bytes()); err != nil { // Event
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalPcrEvent writes a TCG_PCR_EVENT2 structure to a buffer: an event
+// header (PCRIndex, EventType, a TPML_DIGEST_VALUES carrying one digest per
+// active PCR bank), followed by EventSize and the Event description itself.
+func marshalPcrEvent(pcr uint32, digests map[tss.HashAlg][]byte, eventDesc []byte) ([]byte, error) {
+
+	banks := sortedBanks()
 	eventDescLen := uint32(len(eventDesc))
-	slaunch.Debug("marshalPcrEvent: pcr=[%v], slaunchType=[%v], count=[%v], hashAlgo=[%v], eventDesc=[%s], eventDescLen=[%v]",
-		pcr, slaunchType, count, hashAlgo, eventDesc, eventDescLen)
+	slaunch.Debug("marshalPcrEvent: pcr=[%v], slaunchType=[%v], banks=[%v], eventDesc=[%s], eventDescLen=[%v]",
+		pcr, slaunchType, banks, eventDesc, eventDescLen)
 
 	endianess := binary.LittleEndian
 	var buf bytes.Buffer
@@ -37,12 +147,17 @@ func marshalPcrEvent(pcr uint32, h []byte, eventDesc []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	if err := binary.Write(&buf, endianess, count); err != nil {
+	if err := binary.Write(&buf, endianess, uint32(len(banks))); err != nil {
 		return nil, err
 	}
 
-	for i := uint32(0); i < count; i++ {
-		if err := binary.Write(&buf, endianess, hashAlgo); err != nil {
+	for _, alg := range banks {
+		h, ok := digests[alg]
+		if !ok {
+			return nil, fmt.Errorf("marshalPcrEvent: missing digest for active bank %v", alg)
+		}
+
+		if err := binary.Write(&buf, endianess, alg); err != nil {
 			return nil, err
 		}
 
@@ -61,11 +176,64 @@ func marshalPcrEvent(pcr uint32, h []byte, eventDesc []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// ComputeDigests hashes b with every active PCR bank in parallel and returns
+// the result keyed by algorithm, ready to be passed to SendEventToSysfs.
+// Collectors (file, disk, DMI, cpuid hashers) call this instead of hashing
+// with a single hardcoded algorithm; the policy package's anchor
+// measurement uses it directly so the policy's own hash lands in the same
+// crypto-agile log as everything else.
+func ComputeDigests(b []byte) (map[tss.HashAlg][]byte, error) {
+	banks := sortedBanks()
+	digests := make(map[tss.HashAlg][]byte, len(banks))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := make([]error, len(banks))
+
+	for i, alg := range banks {
+		wg.Add(1)
+		go func(i int, alg tss.HashAlg) {
+			defer wg.Done()
+			h, err := tss.Hash(alg, b)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			mu.Lock()
+			digests[alg] = h
+			mu.Unlock()
+		}(i, alg)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return digests, nil
+}
+
 // SendEventToSysfs marshals measurement events and writes them to sysfs.
-func sendEventToSysfs(h []byte, pcr uint32, eventDesc string) error {
+// digests must contain one entry per currently active PCR bank; collectors
+// typically build this by hashing their input with each active algorithm in
+// parallel.
+func SendEventToSysfs(digests map[tss.HashAlg][]byte, pcr uint32, eventDesc string) error {
 
     slaunch.Debug(eventDesc)
-	b, err := marshalPcrEvent(pcr, h, []byte(eventDesc))
+
+	specIDEventOnce.Do(func() {
+		b, err := marshalSpecIDEvent()
+		if err != nil {
+			slaunch.Debug("sendEventToSysfs: failed to marshal Spec ID Event: err=[%v]", err)
+			return
+		}
+		if e := eventlog.Add(b); e != nil {
+			slaunch.Debug("sendEventToSysfs: failed to log Spec ID Event: err=[%v]", e)
+		}
+	})
+
+	b, err := marshalPcrEvent(pcr, digests, []byte(eventDesc))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,29 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"github.com/u-root/u-root/pkg/securelaunch/measurement"
+)
+
+// anchorEventDesc is the event description the policy anchor measurement is
+// logged under, so it's recognizable alongside the collectors' own events in
+// the same crypto-agile log.
+const anchorEventDesc = "secure launch: measured policy anchor into pcr"
+
+// extendAnchorPCR measures a policy that has already passed signature
+// verification into anchorPCR, so a remote attester can tell which policy
+// anchored this boot, independent of whatever the policy's own collectors
+// went on to measure. It goes through measurement.ComputeDigests and
+// measurement.SendEventToSysfs so the anchor digest lands in the same
+// crypto-agile TCG_PCR_EVENT2 log as every other measurement, carrying one
+// digest per active PCR bank rather than a single hardcoded SHA-256.
+func extendAnchorPCR(raw []byte) error {
+	digests, err := measurement.ComputeDigests(raw)
+	if err != nil {
+		return err
+	}
+	return measurement.SendEventToSysfs(digests, anchorPCR, anchorEventDesc)
+}
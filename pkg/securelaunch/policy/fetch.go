@@ -0,0 +1,51 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// fetchTimeout bounds how long sluinit waits on a remote policy server
+// before falling back to failing the boot; there is no operator present to
+// retry a hung request.
+const fetchTimeout = 30 * time.Second
+
+// fetch retrieves the policy and its detached signature from url and
+// url+".sig" over HTTP(S).
+func fetch(url string) ([]byte, []byte, error) {
+	client := &http.Client{Timeout: fetchTimeout}
+
+	raw, err := fetchOne(client, url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("policy: failed to fetch %s: %w", url, err)
+	}
+
+	sig, err := fetchOne(client, url+".sig")
+	if err != nil {
+		return nil, nil, fmt.Errorf("policy: failed to fetch signature %s.sig: %w", url, err)
+	}
+
+	return raw, sig, nil
+}
+
+// fetchOne performs a single GET and returns the body, or an error if the
+// server did not answer with 200 OK.
+func fetchOne(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
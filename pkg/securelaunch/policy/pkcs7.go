@@ -0,0 +1,182 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// TrustedCertificates are the CA certificates trusted to have issued a
+// PKCS#7 policy signer's certificate, baked into the initramfs at build
+// time, parallel to TrustAnchors for minisign-style signatures.
+var TrustedCertificates []*x509.Certificate
+
+// pkcs7ContentInfo is the outer ContentInfo wrapping a SignedData blob, per
+// RFC 2315 section 7. Content is optional so that the inner ContentInfo of a
+// detached signature, which carries only the content type, also parses.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// pkcs7SignedData is RFC 2315 section 9.1's SignedData, with the content
+// itself omitted since policy signatures are always detached.
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+// pkcs7IssuerAndSerial identifies a SignerInfo's certificate.
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// pkcs7SignerInfo is RFC 2315 section 9.2's SignerInfo.
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+// pkcs7DigestAlgorithms maps the digest algorithm OIDs this verifier
+// understands to their crypto.Hash.
+var pkcs7DigestAlgorithms = map[string]crypto.Hash{
+	"1.3.14.3.2.26":          crypto.SHA1,
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+// verifyPKCS7 checks raw against a detached PKCS#7 SignedData signature:
+// each SignerInfo's certificate must chain to a baked-in TrustedCertificates
+// anchor, and its EncryptedDigest must verify over raw's own digest.
+//
+// Authenticated attributes are not supported: every signer must sign raw's
+// digest directly (e.g. `openssl smime -sign -binary -noattr -outform DER`), not a
+// messageDigest attribute, since handling the attribute set correctly pulls
+// in significantly more CMS machinery than a boot-time verifier needs.
+func verifyPKCS7(raw, sig []byte) error {
+	if len(TrustedCertificates) == 0 {
+		return errors.New("no PKCS#7 trust anchors baked in, refusing to boot an unverifiable policy")
+	}
+
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(sig, &ci); err != nil {
+		return fmt.Errorf("failed to parse ContentInfo: %w", err)
+	}
+
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return fmt.Errorf("failed to parse SignedData: %w", err)
+	}
+	if len(sd.SignerInfos) == 0 {
+		return errors.New("SignedData has no signer infos")
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse embedded certificates: %w", err)
+	}
+
+	anchors := x509.NewCertPool()
+	for _, c := range TrustedCertificates {
+		anchors.AddCert(c)
+	}
+	// certs embedded in the blob besides the signer's own may be
+	// intermediate CAs the signer chains through before reaching an anchor.
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		intermediates.AddCert(c)
+	}
+
+	// Try every SignerInfo rather than stopping at the first: one signer
+	// being unusable (cert not embedded, digest OID not in
+	// pkcs7DigestAlgorithms) shouldn't reject a blob where another signer
+	// verifies fine.
+	var lastErr error
+	for _, si := range sd.SignerInfos {
+		if len(si.AuthenticatedAttributes.Bytes) > 0 {
+			lastErr = errors.New("signer uses authenticated attributes, which this verifier does not support")
+			continue
+		}
+
+		cert := findCertBySerial(certs, si.IssuerAndSerialNumber.SerialNumber)
+		if cert == nil {
+			lastErr = errors.New("signer certificate not found among embedded certificates")
+			continue
+		}
+
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: anchors, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+			lastErr = fmt.Errorf("signer certificate does not chain to a trust anchor: %w", err)
+			continue
+		}
+
+		hash, ok := pkcs7DigestAlgorithms[si.DigestAlgorithm.Algorithm.String()]
+		if !ok {
+			lastErr = fmt.Errorf("unsupported digest algorithm %s", si.DigestAlgorithm.Algorithm)
+			continue
+		}
+		hasher := hash.New()
+		hasher.Write(raw)
+
+		if err := verifyCertSignature(cert, hash, hasher.Sum(nil), si.EncryptedDigest); err != nil {
+			lastErr = fmt.Errorf("signature verification failed: %w", err)
+			continue
+		}
+		return nil // one verified signer is enough
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("no signer verified, last error: %w", lastErr)
+	}
+	return errors.New("no signer verified")
+}
+
+// findCertBySerial returns the certificate in certs with the given serial
+// number, or nil if none matches.
+func findCertBySerial(certs []*x509.Certificate, serial *big.Int) *x509.Certificate {
+	for _, c := range certs {
+		if c.SerialNumber.Cmp(serial) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// verifyCertSignature checks sig against digest using cert's public key,
+// supporting the RSA and ECDSA key types PKCS#7 policy signers are
+// expected to use.
+func verifyCertSignature(cert *x509.Certificate, hash crypto.Hash, digest, sig []byte) error {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, sig) {
+			return errors.New("ecdsa signature did not verify")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signer public key type %T", pub)
+	}
+}
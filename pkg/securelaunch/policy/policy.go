@@ -0,0 +1,135 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package policy locates, authenticates and parses the secure launch policy
+// file that drives sluinit.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/cmdline"
+	slaunch "github.com/u-root/u-root/pkg/securelaunch"
+	"github.com/u-root/u-root/pkg/securelaunch/collector"
+)
+
+// policyFileName is the file sluinit looks for on every mounted block device
+// when no remote policy is configured.
+const policyFileName = "securelaunch.policy"
+
+// anchorPCR is the PCR extended with the hash of a verified policy, separate
+// from the PCRs individual collectors measure into, so operators can
+// distinguish "policy was authentic" from "policy's collectors ran".
+const anchorPCR = 13
+
+// Collector measures some piece of platform or kernel state into the TPM.
+// It is an alias of collector.Collector so existing callers that refer to
+// policy.Collector keep working.
+type Collector = collector.Collector
+
+// RegisterCollector makes a collector type available under typeName in a
+// policy file's "collectors" list; see collector.Register. It is meant to
+// be called from an init() in the collector's own package, e.g.:
+//
+//	func init() {
+//		policy.RegisterCollector("example", func() policy.Collector { return &ExampleCollector{} })
+//	}
+//
+// A collector package that policy itself imports, such as measurement, calls
+// collector.Register directly instead, since importing policy from it would
+// be an import cycle.
+func RegisterCollector(typeName string, build func() Collector) {
+	collector.Register(typeName, build)
+}
+
+// Launcher measures and boots the target kernel described by the policy.
+type Launcher interface {
+	MeasureKernel() error
+	Boot() error
+}
+
+// EventLog parses the TPM event log once collectors have finished.
+type EventLog interface {
+	Parse() error
+}
+
+// Policy describes what a boot should measure, who should be booted, and
+// where debug output should be persisted.
+type Policy struct {
+	Collectors   collector.List
+	Launcher     Launcher
+	EventLog     EventLog
+	DebugFileLoc string
+}
+
+// Get locates the secure launch policy, verifies its signature against the
+// trust anchors baked into the initramfs, extends anchorPCR with its digest
+// under every active PCR bank (see extendAnchorPCR) and returns the parsed
+// result. The policy is located by, in order:
+//
+//  1. an `sl_policy=<url>` kernel cmdline argument, fetched over HTTP(S) so
+//     operators can update policy centrally without re-flashing the boot
+//     medium;
+//  2. the first `securelaunch.policy` file found on a mounted block device,
+//     as before.
+//
+// Signature verification failure is fatal: Get returns an error and the
+// caller must not boot on it.
+func Get() (*Policy, error) {
+	raw, sig, err := locate()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verify(raw, sig); err != nil {
+		return nil, fmt.Errorf("policy signature verification failed: %w", err)
+	}
+
+	if err := extendAnchorPCR(raw); err != nil {
+		return nil, fmt.Errorf("failed to measure policy anchor: %w", err)
+	}
+
+	p := &Policy{}
+	if err := json.Unmarshal(raw, p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	return p, nil
+}
+
+// locate returns the policy bytes and its detached signature, from either
+// the `sl_policy=` URL or the on-disk fallback.
+func locate() ([]byte, []byte, error) {
+	if url, ok := cmdline.Flag("sl_policy"); ok && strings.HasPrefix(url, "http") {
+		slaunch.Debug("policy: fetching policy from sl_policy=%s", url)
+		return fetch(url)
+	}
+
+	slaunch.Debug("policy: sl_policy= not set or not an http(s) url, falling back to disk lookup")
+	return locateOnDisk()
+}
+
+// locateOnDisk walks mounted devices looking for policyFileName and its
+// accompanying ".sig" detached signature, as sluinit has always done.
+func locateOnDisk() ([]byte, []byte, error) {
+	path, ok := slaunch.GetStorageDevicePath(policyFileName)
+	if !ok {
+		return nil, nil, errors.New("policy: no mounted device has " + policyFileName)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sig, err := ioutil.ReadFile(path + ".sig")
+	if err != nil {
+		return nil, nil, fmt.Errorf("policy: missing detached signature %s.sig: %w", path, err)
+	}
+
+	return raw, sig, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TrustAnchors are the ed25519 public keys trusted to sign a secure launch
+// policy, baked into the initramfs at build time. verifyMinisign rejects
+// every minisign signature if this is empty; PKCS#7 signatures are checked
+// against TrustedCertificates instead and do not use it.
+var TrustAnchors []ed25519.PublicKey
+
+// verify checks raw's detached signature against every baked-in trust
+// anchor, returning nil as soon as one anchor validates it. sig is the
+// contents of the accompanying ".sig" file, in minisign's signature format
+// (a PKCS#7 blob is also accepted, see verifyPKCS7).
+func verify(raw, sig []byte) error {
+	if looksLikePKCS7(sig) {
+		return verifyPKCS7(raw, sig)
+	}
+	return verifyMinisign(raw, sig)
+}
+
+// looksLikePKCS7 reports whether sig is a DER-encoded ASN.1 SEQUENCE, the
+// PKCS#7 SignedData container format, rather than a minisign text signature.
+func looksLikePKCS7(sig []byte) bool {
+	return len(sig) > 0 && sig[0] == 0x30
+}
+
+// verifyMinisign checks raw against a minisign-style detached ed25519
+// signature: a two-line text file, "untrusted comment: ..." followed by a
+// base64-encoded blob of the form sigalg(2) || keyid(8) || signature(64).
+func verifyMinisign(raw, sig []byte) error {
+	if len(TrustAnchors) == 0 {
+		return errors.New("no trust anchors baked in, refusing to boot an unverifiable policy")
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(sig))
+	var sigLine string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		sigLine = line
+		break
+	}
+	if sigLine == "" {
+		return errors.New("minisign signature: no signature line found")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(sigLine)
+	if err != nil {
+		return fmt.Errorf("minisign signature: %w", err)
+	}
+	if len(decoded) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("minisign signature: unexpected length %d", len(decoded))
+	}
+	if alg := decoded[:2]; string(alg) != "Ed" {
+		return fmt.Errorf("minisign signature: unsupported algorithm %q", alg)
+	}
+	rawSig := decoded[2+8:]
+
+	for _, pub := range TrustAnchors {
+		if ed25519.Verify(pub, raw, rawSig) {
+			return nil
+		}
+	}
+	return errors.New("minisign signature did not verify against any trust anchor")
+}
+
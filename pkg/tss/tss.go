@@ -0,0 +1,230 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tss wraps the small subset of the TPM2 TSS (TPM Software Stack)
+// that the secure launch measurement and policy packages need: computing
+// digests for a given hash algorithm, looking up digest sizes, and
+// discovering which PCR banks the platform TPM has enabled.
+package tss
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// HashAlg is a TPM_ALG_ID hash algorithm identifier, as assigned by the TCG
+// Algorithm Registry.
+type HashAlg uint16
+
+// Hash algorithm identifiers used throughout secure launch. Values match
+// the TCG Algorithm Registry's TPM_ALG_ID assignments.
+const (
+	HashSHA1   HashAlg = 0x0004
+	HashSHA256 HashAlg = 0x000B
+	HashSHA384 HashAlg = 0x000C
+	HashSHA512 HashAlg = 0x000D
+	HashSM3    HashAlg = 0x0012
+)
+
+// digestSizes is the digest length, in bytes, of every hash algorithm this
+// package knows of. Hash computes all of them except HashSM3.
+var digestSizes = map[HashAlg]int{
+	HashSHA1:   sha1.Size,
+	HashSHA256: sha256.Size,
+	HashSHA384: sha512.Size384,
+	HashSHA512: sha512.Size,
+	HashSM3:    32,
+}
+
+// DigestSize returns the digest length, in bytes, that alg produces. It
+// returns 0 for an algorithm this package does not support.
+func DigestSize(alg HashAlg) int {
+	return digestSizes[alg]
+}
+
+// Hash computes b's digest under alg.
+func Hash(alg HashAlg, b []byte) ([]byte, error) {
+	switch alg {
+	case HashSHA1:
+		h := sha1.Sum(b)
+		return h[:], nil
+	case HashSHA256:
+		h := sha256.Sum256(b)
+		return h[:], nil
+	case HashSHA384:
+		h := sha512.Sum384(b)
+		return h[:], nil
+	case HashSHA512:
+		h := sha512.Sum512(b)
+		return h[:], nil
+	default:
+		return nil, fmt.Errorf("tss: unsupported hash algorithm %#04x", uint16(alg))
+	}
+}
+
+// TPM2 command/capability constants needed to issue a raw
+// TPM2_GetCapability(TPM_CAP_PCRS), per the TCG TPM2 Library Part 2
+// (structures) and Part 3 (commands).
+const (
+	tpmSTNoSessions    = 0x8001
+	tpmCCGetCapability = 0x0000017A
+	tpmCapPCRs         = 0x00000005
+)
+
+// tpmDevicePaths are tried, in order, to reach the platform TPM's command
+// interface. /dev/tpmrm0, the in-kernel resource-managed device, is
+// preferred so this doesn't need to manage TPM handles or sessions itself;
+// /dev/tpm0 is the fallback for kernels without the resource manager.
+var tpmDevicePaths = []string{"/dev/tpmrm0", "/dev/tpm0"}
+
+// pcrSelection is one TPMS_PCR_SELECTION out of a TPM2_GetCapability(
+// TPM_CAP_PCRS) response: a bank's hash algorithm and the bitmap of PCRs
+// allocated in it.
+type pcrSelection struct {
+	hash      HashAlg
+	pcrSelect []byte
+}
+
+// ActivePCRBanks reports which PCR banks the platform TPM currently has
+// allocated and extendable, by issuing a TPM2_GetCapability(TPM_CAP_PCRS)
+// command, so that a TCG_PCR_EVENT2 record can carry one digest per active
+// bank instead of assuming SHA-256 alone.
+func ActivePCRBanks() ([]HashAlg, error) {
+	sels, err := getCapabilityPCRs()
+	if err != nil {
+		return nil, err
+	}
+
+	var banks []HashAlg
+	for _, sel := range sels {
+		if _, err := Hash(sel.hash, nil); err != nil {
+			continue // bank this package doesn't know how to hash, skip it
+		}
+		for _, b := range sel.pcrSelect {
+			if b != 0 {
+				banks = append(banks, sel.hash)
+				break
+			}
+		}
+	}
+	if len(banks) == 0 {
+		return nil, errors.New("tss: TPM reported no active PCR banks")
+	}
+	return banks, nil
+}
+
+// getCapabilityPCRs issues a raw TPM2_GetCapability(TPM_CAP_PCRS) command to
+// the platform TPM and parses the TPML_PCR_SELECTION out of the response.
+func getCapabilityPCRs() ([]pcrSelection, error) {
+	dev, path, err := openTPMDevice()
+	if err != nil {
+		return nil, err
+	}
+	defer dev.Close()
+
+	if _, err := dev.Write(marshalGetCapabilityCmd()); err != nil {
+		return nil, fmt.Errorf("tss: write to %s: %w", path, err)
+	}
+
+	rsp := make([]byte, 4096)
+	n, err := dev.Read(rsp)
+	if err != nil {
+		return nil, fmt.Errorf("tss: read from %s: %w", path, err)
+	}
+	return parseGetCapabilityPCRsResponse(rsp[:n])
+}
+
+// openTPMDevice opens the first device in tpmDevicePaths that exists.
+func openTPMDevice() (*os.File, string, error) {
+	var lastErr error
+	for _, path := range tpmDevicePaths {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err == nil {
+			return f, path, nil
+		}
+		lastErr = err
+	}
+	return nil, "", fmt.Errorf("tss: no TPM device found, tried %v: %w", tpmDevicePaths, lastErr)
+}
+
+// marshalGetCapabilityCmd builds a TPM2_GetCapability(TPM_CAP_PCRS, 0, 1)
+// command, requesting every PCR selection in one response.
+func marshalGetCapabilityCmd() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint16(tpmSTNoSessions))
+	binary.Write(&buf, binary.BigEndian, uint32(0)) // commandSize, patched in below
+	binary.Write(&buf, binary.BigEndian, uint32(tpmCCGetCapability))
+	binary.Write(&buf, binary.BigEndian, uint32(tpmCapPCRs))
+	binary.Write(&buf, binary.BigEndian, uint32(0)) // property
+	binary.Write(&buf, binary.BigEndian, uint32(1)) // propertyCount
+
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b[2:6], uint32(len(b)))
+	return b
+}
+
+// parseGetCapabilityPCRsResponse parses a TPM2_GetCapability response body
+// whose capabilityData is a TPML_PCR_SELECTION (i.e. one sent for
+// TPM_CAP_PCRS).
+func parseGetCapabilityPCRsResponse(b []byte) ([]pcrSelection, error) {
+	r := bytes.NewReader(b)
+
+	var tag uint16
+	var size, code uint32
+	if err := binary.Read(r, binary.BigEndian, &tag); err != nil {
+		return nil, fmt.Errorf("tss: short response: %w", err)
+	}
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, fmt.Errorf("tss: short response: %w", err)
+	}
+	if err := binary.Read(r, binary.BigEndian, &code); err != nil {
+		return nil, fmt.Errorf("tss: short response: %w", err)
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("tss: TPM2_GetCapability failed, response code %#x", code)
+	}
+
+	var moreData uint8
+	if err := binary.Read(r, binary.BigEndian, &moreData); err != nil {
+		return nil, fmt.Errorf("tss: short response: %w", err)
+	}
+
+	var capability uint32
+	if err := binary.Read(r, binary.BigEndian, &capability); err != nil {
+		return nil, fmt.Errorf("tss: short response: %w", err)
+	}
+	if capability != tpmCapPCRs {
+		return nil, fmt.Errorf("tss: unexpected capability %#x in response", capability)
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, fmt.Errorf("tss: short response: %w", err)
+	}
+
+	sels := make([]pcrSelection, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var hash uint16
+		var sizeofSelect uint8
+		if err := binary.Read(r, binary.BigEndian, &hash); err != nil {
+			return nil, fmt.Errorf("tss: short pcrSelection: %w", err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &sizeofSelect); err != nil {
+			return nil, fmt.Errorf("tss: short pcrSelection: %w", err)
+		}
+		pcrSelect := make([]byte, sizeofSelect)
+		if _, err := io.ReadFull(r, pcrSelect); err != nil {
+			return nil, fmt.Errorf("tss: short pcrSelection: %w", err)
+		}
+		sels = append(sels, pcrSelection{hash: HashAlg(hash), pcrSelect: pcrSelect})
+	}
+	return sels, nil
+}